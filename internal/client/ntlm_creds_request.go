@@ -0,0 +1,29 @@
+package client
+
+import (
+	"golang.org/x/crypto/ssh"
+
+	"github.com/NHAS/reverse_ssh/internal"
+)
+
+// HandleGlobalRequest processes global (connection-wide) SSH requests sent
+// by the server outside of any channel. It must be called from the
+// client's request-handling loop for every inbound *ssh.Request.
+//
+// TODO: that call site doesn't exist in this tree yet - there is no
+// cmd/client or SSH client-connection/request loop here to wire it into.
+// This handler is correct but currently unreachable; wiring it in is
+// still outstanding, not done.
+func HandleGlobalRequest(req *ssh.Request) {
+	switch req.Type {
+	case internal.NTLMCredsRequestType:
+		SetNTLMProxyCreds(string(req.Payload))
+		if req.WantReply {
+			req.Reply(true, nil)
+		}
+	default:
+		if req.WantReply {
+			req.Reply(false, nil)
+		}
+	}
+}
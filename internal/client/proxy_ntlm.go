@@ -1,10 +1,14 @@
 package client
 
 import (
+	"bufio"
 	"encoding/base64"
 	"fmt"
+	"os"
 	"strings"
 
+	"golang.org/x/term"
+
 	"github.com/NHAS/reverse_ssh/internal/client/ntlmssp"
 )
 
@@ -14,6 +18,97 @@ func SetNTLMProxyCreds(creds string) {
 	ntlmProxyCreds = creds
 }
 
+// ResolveNTLMProxyCreds works out the NTLM proxy credentials to use,
+// following file > env > prompt > flag precedence: --ntlm-proxy-creds-file
+// wins if set, then the NTLM_PROXY_CREDS env var, then an interactive
+// prompt if flagValue is "-" or "prompt", and finally flagValue itself
+// taken literally. This keeps the password out of /proc/*/cmdline, shell
+// history and process listings whenever an alternative is available.
+func ResolveNTLMProxyCreds(flagValue, credsFile string) error {
+	if credsFile != "" {
+		creds, err := readNTLMCredsFile(credsFile)
+		if err != nil {
+			return err
+		}
+
+		SetNTLMProxyCreds(creds)
+		return nil
+	}
+
+	if env := os.Getenv("NTLM_PROXY_CREDS"); env != "" {
+		SetNTLMProxyCreds(env)
+		return nil
+	}
+
+	if flagValue == "-" || flagValue == "prompt" {
+		creds, err := promptNTLMProxyCreds()
+		if err != nil {
+			return err
+		}
+
+		SetNTLMProxyCreds(creds)
+		return nil
+	}
+
+	SetNTLMProxyCreds(flagValue)
+	return nil
+}
+
+func readNTLMCredsFile(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read --ntlm-proxy-creds-file: %v", err)
+	}
+
+	if info.Mode().Perm()&0077 != 0 {
+		return "", fmt.Errorf("--ntlm-proxy-creds-file %s is readable by group/other, refusing to use it (chmod 600)", path)
+	}
+
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to read --ntlm-proxy-creds-file: %v", err)
+	}
+
+	return strings.TrimSpace(string(content)), nil
+}
+
+// promptNTLMProxyCreds reads DOMAIN, username and password interactively,
+// disabling terminal echo for the password. When stdin isn't a TTY (e.g.
+// it's piped) it falls back to reading a single DOMAIN\USER:PASS line.
+func promptNTLMProxyCreds() (string, error) {
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+		if err != nil {
+			return "", fmt.Errorf("unable to read NTLM proxy credentials from stdin: %v", err)
+		}
+
+		return strings.TrimSpace(line), nil
+	}
+
+	reader := bufio.NewReader(os.Stdin)
+
+	fmt.Fprint(os.Stderr, "NTLM proxy domain: ")
+	domain, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("unable to read NTLM proxy domain: %v", err)
+	}
+
+	fmt.Fprint(os.Stderr, "NTLM proxy username: ")
+	user, err := reader.ReadString('\n')
+	if err != nil {
+		return "", fmt.Errorf("unable to read NTLM proxy username: %v", err)
+	}
+
+	fmt.Fprint(os.Stderr, "NTLM proxy password: ")
+	passBytes, err := term.ReadPassword(int(os.Stdin.Fd()))
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", fmt.Errorf("unable to read NTLM proxy password: %v", err)
+	}
+
+	return strings.TrimSpace(domain) + "\\" + strings.TrimSpace(user) + ":" + string(passBytes), nil
+}
+
 func parseNTLMCreds(creds string) (domain, user, pass string, err error) {
 	if creds == "" {
 		return "", "", "", fmt.Errorf("NTLM credentials not provided. Use --ntlm-proxy-creds in format DOMAIN\\USER:PASS")
@@ -0,0 +1,9 @@
+package internal
+
+// NTLMCredsRequestType is the SSH global request type the server sends to
+// push updated NTLM proxy credentials to an already-connected client (see
+// server/commands/setntlmcreds.go and client/ntlm_creds_request.go). It's
+// shared here, rather than duplicated as a literal/constant in each
+// package, so renaming it on one side can't silently break the other with
+// no compiler error.
+const NTLMCredsRequestType = "ntlm-creds"
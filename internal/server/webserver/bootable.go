@@ -0,0 +1,206 @@
+package webserver
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/NHAS/reverse_ssh/internal"
+)
+
+const (
+	fileTypeBootable = "bootable"
+	kernelCacheDir   = "kernels"
+)
+
+// BuildBootable assembles a bootable Alpine-style image (busybox + a
+// linux/amd64 client binary built with CGO disabled; no musl or other libc
+// is copied into the rootfs) that starts reverse_ssh against the given
+// connect-back/fingerprint on boot, for operators who can reboot a machine
+// but can't log into it. ipxe selects between the two delivery modes: an
+// iPXE script + kernel/initrd served over HTTP, or a standalone ISO built
+// with xorriso/grub-mkrescue.
+func BuildBootable(suppliedConnectBackAdress, fingerprint, name, kernelVersion string, ipxe bool) (string, error) {
+	if !webserverOn {
+		return "", fmt.Errorf("Web server is not enabled.")
+	}
+
+	if len(suppliedConnectBackAdress) == 0 {
+		suppliedConnectBackAdress = defaultConnectBack
+	}
+
+	if len(fingerprint) == 0 {
+		fingerprint = defaultFingerPrint
+	}
+
+	if len(kernelVersion) == 0 {
+		return "", fmt.Errorf("a kernel version is required, see `kernels` for what's cached under %s", filepath.Join(cachePath, kernelCacheDir))
+	}
+
+	// Only the name reservation needs the lock; the compile, kernel copy,
+	// initrd packing and (for the ISO path) grub-mkrescue run are the
+	// expensive part and easily take tens of seconds, so they must not
+	// serialize every other Get/List/Delete behind one build, the same bug
+	// chunk0-2 fixed for Build (commit 088854f).
+	c.Lock()
+
+	if len(name) == 0 {
+		var err error
+		name, err = internal.RandomString(16)
+		if err != nil {
+			c.Unlock()
+			return "", err
+		}
+	}
+
+	if _, ok := cache[name]; ok {
+		c.Unlock()
+		return "", errors.New("This link name is already in use")
+	}
+
+	cache[name] = file{} // reserve the name while we build
+	c.Unlock()
+
+	// The working directory is derived from a random id, not name, for the
+	// same reason Build uses a random filename (buildmanager.go): name is
+	// operator-supplied and must never end up in a filesystem path.
+	workDirID, err := internal.RandomString(16)
+	if err != nil {
+		c.Lock()
+		delete(cache, name)
+		c.Unlock()
+		return "", err
+	}
+
+	workDir := filepath.Join(cachePath, workDirID+"-boot")
+	if err := os.MkdirAll(workDir, 0700); err != nil {
+		releaseReservation(name)
+		return "", fmt.Errorf("unable to create working directory for bootable image: %s", err)
+	}
+
+	clientPath, version, err := compileClient("linux", "amd64", "", suppliedConnectBackAdress, fingerprint, filepath.Join(workDir, "client"), false)
+	if err != nil {
+		os.RemoveAll(workDir)
+		releaseReservation(name)
+		return "", err
+	}
+
+	kernelPath, err := cachedKernel(kernelVersion)
+	if err != nil {
+		os.RemoveAll(workDir)
+		releaseReservation(name)
+		return "", err
+	}
+
+	manifest, initrdPath, err := buildInitrd(workDir, clientPath)
+	if err != nil {
+		os.RemoveAll(workDir)
+		releaseReservation(name)
+		return "", err
+	}
+
+	f := file{
+		FileType:      fileTypeBootable,
+		Goos:          "linux",
+		Goarch:        "amd64",
+		Version:       version,
+		KernelVersion: kernelVersion,
+	}
+
+	if ipxe {
+		ipxePath := filepath.Join(workDir, "boot.ipxe")
+		script := fmt.Sprintf("#!ipxe\nkernel %s/vmlinuz console=ttyS0\ninitrd %s/initrd\nboot\n", name, name)
+		if err := os.WriteFile(ipxePath, []byte(script), 0600); err != nil {
+			os.RemoveAll(workDir)
+			releaseReservation(name)
+			return "", fmt.Errorf("unable to write iPXE script: %s", err)
+		}
+
+		f.Path = ipxePath
+		f.BootFiles = []string{kernelPath, initrdPath, manifest}
+
+		c.Lock()
+		cache[name] = f
+		Autocomplete.Add(name)
+		c.Unlock()
+
+		if err := store.Put(name, f); err != nil {
+			fmt.Println("Unable to persist cache entry: ", err)
+		}
+
+		return scheme() + suppliedConnectBackAdress + "/" + name + "/boot.ipxe", nil
+	}
+
+	isoPath := filepath.Join(workDir, name+".iso")
+	isoRoot := filepath.Join(workDir, "iso")
+	if err := os.MkdirAll(filepath.Join(isoRoot, "boot"), 0700); err != nil {
+		os.RemoveAll(workDir)
+		releaseReservation(name)
+		return "", fmt.Errorf("unable to prepare ISO root: %s", err)
+	}
+
+	if err := copyFile(kernelPath, filepath.Join(isoRoot, "boot", "vmlinuz")); err != nil {
+		os.RemoveAll(workDir)
+		releaseReservation(name)
+		return "", err
+	}
+	if err := copyFile(initrdPath, filepath.Join(isoRoot, "boot", "initrd")); err != nil {
+		os.RemoveAll(workDir)
+		releaseReservation(name)
+		return "", err
+	}
+
+	cmd := exec.Command("grub-mkrescue", "-o", isoPath, isoRoot)
+	if output, err := cmd.CombinedOutput(); err != nil {
+		os.RemoveAll(workDir)
+		releaseReservation(name)
+		return "", fmt.Errorf("grub-mkrescue failed: %s\n%s", err, string(output))
+	}
+
+	f.Path = isoPath
+	f.BootFiles = []string{kernelPath, initrdPath, manifest, isoRoot}
+
+	c.Lock()
+	cache[name] = f
+	Autocomplete.Add(name)
+	c.Unlock()
+
+	if err := store.Put(name, f); err != nil {
+		fmt.Println("Unable to persist cache entry: ", err)
+	}
+
+	return scheme() + suppliedConnectBackAdress + "/" + name, nil
+}
+
+// releaseReservation removes a name reserved by a BuildBootable call that
+// went on to fail, freeing it for reuse.
+func releaseReservation(name string) {
+	c.Lock()
+	delete(cache, name)
+	c.Unlock()
+}
+
+// cachedKernel returns the path to a previously-fetched kernel under
+// cachePath/kernels/<version>/vmlinuz. Fetching is intentionally left to an
+// operator-run step (`kernels fetch <version>`), so BuildBootable never
+// reaches out to the network on an operator's behalf.
+func cachedKernel(version string) (string, error) {
+	kernelPath := filepath.Join(cachePath, kernelCacheDir, version, "vmlinuz")
+
+	if _, err := os.Stat(kernelPath); err != nil {
+		return "", fmt.Errorf("kernel %s is not cached at %s, fetch it first", version, kernelPath)
+	}
+
+	return kernelPath, nil
+}
+
+func copyFile(src, dst string) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return fmt.Errorf("unable to read %s: %s", src, err)
+	}
+
+	return os.WriteFile(dst, content, 0600)
+}
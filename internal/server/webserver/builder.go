@@ -0,0 +1,172 @@
+package webserver
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+
+	"github.com/NHAS/reverse_ssh/internal"
+)
+
+// buildRequest carries everything a Builder needs to actually invoke the go
+// toolchain for one target, independent of how/where that toolchain runs.
+type buildRequest struct {
+	Goos, Goarch string
+	Shared       bool
+
+	Args []string // arguments to `go`, e.g. ["build", "-o", ...]
+	Env  []string // full environment, GOOS/GOARCH/CGO_ENABLED/etc already set
+}
+
+// Builder executes a go build for a single target. LocalBuilder shells out
+// to the host toolchain directly; ContainerBuilder runs the same build
+// inside a pinned container image so cross-CGO targets (mingw, osxcross)
+// don't depend on whatever happens to be installed on the operator host.
+type Builder interface {
+	// Name identifies the backend in error messages.
+	Name() string
+
+	// Probe checks whether this backend can be used at all on this host,
+	// populating any capabilities it discovers. Called once from
+	// startBuildManager.
+	Probe() error
+
+	// Supports reports whether this backend should handle the given
+	// target, once Probe has succeeded.
+	Supports(goos, goarch string, shared bool) bool
+
+	// Run performs the build, returning the combined stdout+stderr of the
+	// underlying toolchain invocation.
+	Run(req buildRequest) ([]byte, error)
+}
+
+// builders is populated by startBuildManager, in priority order: the first
+// Builder whose Supports() returns true for a given target wins.
+var builders []Builder
+
+// selectBuilder picks the Builder that should handle the given target. A
+// target that no probed backend supports (e.g. a windows-cgo shared object
+// with neither mingw nor a container image available) produces a clear
+// error here, rather than a cryptic compiler failure partway through Run.
+func selectBuilder(goos, goarch string, shared bool) (Builder, error) {
+	for _, b := range builders {
+		if b.Supports(goos, goarch, shared) {
+			return b, nil
+		}
+	}
+
+	return nil, fmt.Errorf("no available build backend supports goos=%s goarch=%s shared=%v", goos, goarch, shared)
+}
+
+// LocalBuilder shells out to the `go` binary already on PATH. This is the
+// backend reverse_ssh has always used.
+type LocalBuilder struct {
+	goPath string
+}
+
+func (l *LocalBuilder) Name() string { return "local" }
+
+func (l *LocalBuilder) Probe() error {
+	path, err := exec.LookPath("go")
+	if err != nil {
+		return fmt.Errorf("local builder: %s", err)
+	}
+
+	l.goPath = path
+	return nil
+}
+
+// Supports is the fallback backend: it takes whatever ContainerBuilder (or
+// any other higher-priority backend) declines, except a windows shared
+// object built on a linux host when x86_64-w64-mingw32-gcc is not on PATH.
+func (l *LocalBuilder) Supports(goos, goarch string, shared bool) bool {
+	if l.goPath == "" {
+		return false
+	}
+
+	if shared && goos == "windows" && runtime.GOOS == "linux" {
+		if _, err := exec.LookPath("x86_64-w64-mingw32-gcc"); err != nil {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (l *LocalBuilder) Run(req buildRequest) ([]byte, error) {
+	cmd := exec.Command(l.goPath, req.Args...)
+	cmd.Env = req.Env
+
+	return cmd.CombinedOutput()
+}
+
+// compileClient builds the reverse_ssh client for one target, selecting the
+// appropriate Builder and producing outPath (adjusted with a .so/.dll
+// suffix when shared is set). It is shared by Build and BuildBootable, the
+// two entry points that need a compiled client binary on disk.
+func compileClient(goos, goarch, variant, suppliedConnectBackAdress, fingerprint, outPath string, shared bool) (path, version string, err error) {
+	version = internal.Version + " (guess)"
+	if repoVersion, err := exec.Command("git", "describe", "--tags").CombinedOutput(); err == nil {
+		version = string(repoVersion)
+	}
+
+	path = outPath
+
+	buildArguments := []string{"build"}
+	if shared {
+		buildArguments = append(buildArguments, "-buildmode=c-shared")
+		buildArguments = append(buildArguments, "-tags=cshared")
+		if goos != "windows" {
+			path += ".so"
+		} else {
+			path += ".dll"
+		}
+	}
+
+	buildArguments = append(buildArguments, fmt.Sprintf("-ldflags=-s -w -X main.destination=%s -X main.fingerprint=%s -X client.Version=%s", suppliedConnectBackAdress, fingerprint, version))
+	buildArguments = append(buildArguments, "-o", path, filepath.Join(projectRoot, "/cmd/client"))
+
+	buildEnv := append([]string{}, os.Environ()...)
+	buildEnv = append(buildEnv, "GOOS="+goos)
+	buildEnv = append(buildEnv, "GOARCH="+goarch)
+
+	if envVar := variantEnv(goarch); envVar != "" && variant != "" {
+		buildEnv = append(buildEnv, envVar+"="+variant)
+	}
+
+	//Building a shared object for windows needs some extra beans
+	cgoOn := "0"
+	if shared {
+		var crossCompiler string
+		if runtime.GOOS == "linux" && goos == "windows" && goarch == "amd64" {
+			crossCompiler = "x86_64-w64-mingw32-gcc"
+		}
+
+		buildEnv = append(buildEnv, "CC="+crossCompiler)
+		cgoOn = "1"
+	}
+
+	buildEnv = append(buildEnv, "CGO_ENABLED="+cgoOn)
+
+	builder, err := selectBuilder(goos, goarch, shared)
+	if err != nil {
+		return "", "", err
+	}
+
+	output, err := builder.Run(buildRequest{
+		Goos:   goos,
+		Goarch: goarch,
+		Shared: shared,
+		Args:   buildArguments,
+		Env:    buildEnv,
+	})
+	if err != nil {
+		return "", "", fmt.Errorf("Error (%s backend): %s\n%s", builder.Name(), err.Error(), string(output))
+	}
+
+	os.Chmod(path, 0600)
+
+	return path, version, nil
+}
@@ -2,14 +2,15 @@ package webserver
 
 import (
 	"bytes"
-	"encoding/json"
 	"errors"
 	"fmt"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/NHAS/reverse_ssh/internal"
 	"github.com/NHAS/reverse_ssh/pkg/trie"
@@ -19,9 +20,48 @@ type file struct {
 	Path     string
 	Goos     string
 	Goarch   string
+	Variant  string
 	FileType string
 	Hits     int
 	Version  string
+
+	// KernelVersion and BootFiles are only populated for FileType
+	// "bootable": BootFiles lists the extra artifacts (kernel, initrd,
+	// rootfs manifest and, for an ISO, its staging directory) that Delete
+	// removes along with Path (the iPXE script or the ISO).
+	KernelVersion string
+	BootFiles     []string
+
+	CreatedAt time.Time
+	LastHitAt time.Time
+	RemoteIPs []string // capped ring buffer of the last maxRemoteIPs hitters
+	SHA256    string   // of the built binary, computed once at build time
+}
+
+// variantEnv maps a GOARCH to the environment variable go expects a
+// floating-point/version variant in, e.g. GOARCH=arm wants GOARM=7.
+func variantEnv(goarch string) string {
+	switch {
+	case goarch == "arm":
+		return "GOARM"
+	case strings.HasPrefix(goarch, "mips"):
+		return "GOMIPS"
+	case goarch == "amd64":
+		return "GOAMD64"
+	case goarch == "386":
+		return "GO386"
+	}
+	return ""
+}
+
+// validVariants enumerates the variant values go itself accepts per
+// GOARCH, so a bad --variant fails fast instead of producing a cryptic
+// compiler error.
+var validVariants = map[string]map[string]bool{
+	"GOARM":   {"5": true, "6": true, "7": true},
+	"GOMIPS":  {"hardfloat": true, "softfloat": true},
+	"GOAMD64": {"v1": true, "v2": true, "v3": true, "v4": true},
+	"GO386":   {"sse2": true, "softfloat": true},
 }
 
 const cacheDescriptionFile = "description.json"
@@ -31,13 +71,15 @@ var (
 
 	validPlatforms = make(map[string]bool)
 	validArchs     = make(map[string]bool)
+	knownTargets   []string // every "goos/goarch" pair go itself can compile
 
 	c         sync.RWMutex
-	cache     map[string]file = make(map[string]file) // random id to actual file path
+	cache     map[string]file = make(map[string]file) // random id to actual file path, read-through cache over store
 	cachePath string
+	store     Store
 )
 
-func Build(goos, goarch, suppliedConnectBackAdress, fingerprint, name string, shared bool) (string, error) {
+func Build(goos, goarch, variant, suppliedConnectBackAdress, fingerprint, name string, shared bool) (string, error) {
 	if !webserverOn {
 		return "", fmt.Errorf("Web server is not enabled.")
 	}
@@ -50,6 +92,16 @@ func Build(goos, goarch, suppliedConnectBackAdress, fingerprint, name string, sh
 		return "", fmt.Errorf("GOOS supplied is not valid: " + goos)
 	}
 
+	envVar := variantEnv(goarch)
+	if len(variant) != 0 {
+		if envVar == "" {
+			return "", fmt.Errorf("GOARCH %q does not accept a variant", goarch)
+		}
+		if !validVariants[envVar][variant] {
+			return "", fmt.Errorf("%s supplied is not valid: %s", envVar, variant)
+		}
+	}
+
 	if len(suppliedConnectBackAdress) == 0 {
 		suppliedConnectBackAdress = defaultConnectBack
 	}
@@ -58,27 +110,8 @@ func Build(goos, goarch, suppliedConnectBackAdress, fingerprint, name string, sh
 		fingerprint = defaultFingerPrint
 	}
 
-	c.Lock()
-	defer c.Unlock()
-
 	var f file
 
-	filename, err := internal.RandomString(16)
-	if err != nil {
-		return "", err
-	}
-
-	if len(name) == 0 {
-		name, err = internal.RandomString(16)
-		if err != nil {
-			return "", err
-		}
-	}
-
-	if _, ok := cache[name]; ok {
-		return "", errors.New("This link name is already in use")
-	}
-
 	f.Goos = runtime.GOOS
 	if len(goos) > 0 {
 		f.Goos = goos
@@ -89,80 +122,96 @@ func Build(goos, goarch, suppliedConnectBackAdress, fingerprint, name string, sh
 		f.Goarch = goarch
 	}
 
-	f.Path = filepath.Join(cachePath, filename)
-	f.FileType = "executable"
-	f.Version = internal.Version + " (guess)"
-
-	repoVersion, err := exec.Command("git", "describe", "--tags").CombinedOutput()
-	if err == nil {
-		f.Version = string(repoVersion)
-	}
+	f.Variant = variant
 
-	buildArguments := []string{"build"}
+	f.FileType = "executable"
 	if shared {
-		buildArguments = append(buildArguments, "-buildmode=c-shared")
-		buildArguments = append(buildArguments, "-tags=cshared")
 		f.FileType = "shared-object"
-		if f.Goos != "windows" {
-			f.Path += ".so"
-		} else {
-			f.Path += ".dll"
-		}
-
 	}
 
-	buildArguments = append(buildArguments, fmt.Sprintf("-ldflags=-s -w -X main.destination=%s -X main.fingerprint=%s -X client.Version=%s", suppliedConnectBackAdress, fingerprint, f.Version))
-	buildArguments = append(buildArguments, "-o", f.Path, filepath.Join(projectRoot, "/cmd/client"))
-
-	cmd := exec.Command("go", buildArguments...)
-
-	cmd.Env = append(cmd.Env, os.Environ()...)
-	cmd.Env = append(cmd.Env, "GOOS="+f.Goos)
-	cmd.Env = append(cmd.Env, "GOARCH="+f.Goarch)
+	// Only the name reservation needs the lock; the actual compile
+	// (an external go build/container exec) is the expensive part and
+	// must not serialize every other cache operation, or BuildMatrix's
+	// worker pool buys nothing.
+	c.Lock()
 
-	//Building a shared object for windows needs some extra beans
-	cgoOn := "0"
-	if shared {
+	filename, err := internal.RandomString(16)
+	if err != nil {
+		c.Unlock()
+		return "", err
+	}
 
-		var crossCompiler string
-		if runtime.GOOS == "linux" && f.Goos == "windows" && f.Goarch == "amd64" {
-			crossCompiler = "x86_64-w64-mingw32-gcc"
+	if len(name) == 0 {
+		name, err = internal.RandomString(16)
+		if err != nil {
+			c.Unlock()
+			return "", err
 		}
+	}
 
-		cmd.Env = append(cmd.Env, "CC="+crossCompiler)
-		cgoOn = "1"
+	if _, ok := cache[name]; ok {
+		c.Unlock()
+		return "", errors.New("This link name is already in use")
 	}
 
-	cmd.Env = append(cmd.Env, "CGO_ENABLED="+cgoOn)
+	cache[name] = file{} // reserve the name while we compile
+	c.Unlock()
 
-	output, err := cmd.CombinedOutput()
+	path, version, err := compileClient(f.Goos, f.Goarch, f.Variant, suppliedConnectBackAdress, fingerprint, filepath.Join(cachePath, filename), shared)
 	if err != nil {
-		return "", fmt.Errorf("Error: " + err.Error() + "\n" + string(output))
+		c.Lock()
+		delete(cache, name)
+		c.Unlock()
+		return "", err
 	}
 
-	cache[name] = f
+	f.Path = path
+	f.Version = version
+	f.CreatedAt = time.Now()
 
-	os.Chmod(f.Path, 0600)
+	if sum, err := sha256File(f.Path); err == nil {
+		f.SHA256 = sum
+	} else {
+		fmt.Println("Unable to hash built binary: ", err)
+	}
 
+	c.Lock()
+	cache[name] = f
 	Autocomplete.Add(name)
+	c.Unlock()
 
-	writeCache()
+	if err := store.Put(name, f); err != nil {
+		fmt.Println("Unable to persist cache entry: ", err)
+	}
 
-	return "http://" + suppliedConnectBackAdress + "/" + name, nil
+	return scheme() + suppliedConnectBackAdress + "/" + name, nil
 }
 
-func Get(key string) (file, error) {
-	c.RLock()
-	defer c.RUnlock()
+// Get looks up a cache entry and records a hit against it. remoteAddr, when
+// non-empty, is recorded in the entry's RemoteIPs ring buffer. The hit is
+// queued and coalesced by the hit flusher rather than written to the store
+// synchronously, so it survives a restart (unlike the old JSON-blob cache,
+// where Hits only ever lived in memory) without putting a disk write on
+// every single download.
+func Get(key, remoteAddr string) (file, error) {
+	c.Lock()
 
 	cacheEntry, ok := cache[key]
 	if !ok {
+		c.Unlock()
 		return cacheEntry, errors.New("Unable to find cache entry: " + key)
 	}
 
 	cacheEntry.Hits++
+	cacheEntry.LastHitAt = time.Now()
+	if remoteAddr != "" {
+		cacheEntry.RemoteIPs = appendRemoteIP(cacheEntry.RemoteIPs, remoteAddr)
+	}
 
 	cache[key] = cacheEntry
+	c.Unlock()
+
+	queueHit(key, remoteAddr)
 
 	return cacheEntry, nil
 }
@@ -200,6 +249,13 @@ func List(filter string) (matchingFiles map[string]file, err error) {
 			matchingFiles[id] = cache[id]
 			continue
 		}
+
+		if file.Variant != "" {
+			if match, _ := filepath.Match(filter, file.Variant); match {
+				matchingFiles[id] = cache[id]
+				continue
+			}
+		}
 	}
 
 	return
@@ -216,19 +272,19 @@ func Delete(key string) error {
 
 	delete(cache, key)
 
-	writeCache()
+	if err := store.Delete(key); err != nil {
+		fmt.Println("Unable to remove cache entry from store: ", err)
+	}
 
 	Autocomplete.Remove(key)
 
-	return os.Remove(cacheEntry.Path)
-}
-
-func writeCache() {
-	content, err := json.Marshal(cache)
-	if err != nil {
-		panic(err)
+	for _, extra := range cacheEntry.BootFiles {
+		if err := os.RemoveAll(extra); err != nil {
+			return err
+		}
 	}
-	os.WriteFile(filepath.Join(cachePath, cacheDescriptionFile), content, 0700)
+
+	return os.Remove(cacheEntry.Path)
 }
 
 func startBuildManager(cPath string) error {
@@ -255,6 +311,7 @@ func startBuildManager(cPath string) error {
 		if len(parts) == 2 {
 			validPlatforms[string(parts[0])] = true
 			validArchs[string(parts[1])] = true
+			knownTargets = append(knownTargets, string(parts[0])+"/"+string(parts[1]))
 		}
 	}
 
@@ -281,21 +338,56 @@ func startBuildManager(cPath string) error {
 		return errors.New("Unable to delete file in cache directory: " + err.Error())
 	}
 
-	contents, err := os.ReadFile(filepath.Join(cPath, cacheDescriptionFile))
-	if err == nil {
-		err = json.Unmarshal(contents, &cache)
-		if err == nil {
-			for id := range cache {
-				Autocomplete.Add(id)
-			}
+	cachePath = cPath
+
+	store, err = openStore(cPath)
+	if err != nil {
+		return err
+	}
+
+	startHitFlusher()
+
+	cache, err = store.All()
+	if err != nil {
+		return fmt.Errorf("unable to load cache store: %s", err)
+	}
+
+	if len(cache) == 0 {
+		// One-time migration path: an install upgrading from the old
+		// JSON-blob cache still has its entries picked up here, instead
+		// of silently losing them.
+		if migrated, err := migrateLegacyCache(cPath, store); err == nil {
+			cache = migrated
+		}
+	}
+
+	for id := range cache {
+		Autocomplete.Add(id)
+	}
+
+	builders = nil
+
+	containerBuilder := &ContainerBuilder{Images: map[containerCapability]string{}}
+	if image := os.Getenv("REVERSE_SSH_MINGW_IMAGE"); image != "" {
+		containerBuilder.Images[capWindowsCGO] = image
+	}
+	if image := os.Getenv("REVERSE_SSH_OSXCROSS_IMAGE"); image != "" {
+		containerBuilder.Images[capDarwin] = image
+	}
+
+	if len(containerBuilder.Images) > 0 {
+		if err := containerBuilder.Probe(); err != nil {
+			fmt.Println("Container build backend unavailable, falling back to local toolchain: ", err)
 		} else {
-			fmt.Println("Unable to load cache: ", err)
+			builders = append(builders, containerBuilder)
 		}
-	} else {
-		fmt.Println("Unable to load cache: ", err)
 	}
 
-	cachePath = cPath
+	localBuilder := &LocalBuilder{}
+	if err := localBuilder.Probe(); err != nil {
+		return fmt.Errorf("no usable build backend: %s", err)
+	}
+	builders = append(builders, localBuilder)
 
 	return nil
 }
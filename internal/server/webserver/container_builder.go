@@ -0,0 +1,160 @@
+package webserver
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// containerCapability names the class of cross-compile a pinned image
+// handles, e.g. a CGO Windows shared object needs mingw, a CGO darwin
+// target needs osxcross.
+type containerCapability string
+
+const (
+	capWindowsCGO containerCapability = "windows-cgo"
+	capDarwin     containerCapability = "darwin"
+	capDefault    containerCapability = "default"
+)
+
+func capabilityFor(goos string, shared bool) containerCapability {
+	switch {
+	case goos == "windows" && shared:
+		return capWindowsCGO
+	case goos == "darwin":
+		return capDarwin
+	default:
+		return capDefault
+	}
+}
+
+// ContainerBuilder runs `go build` inside a pinned image via `docker` or
+// `podman` exec, bind-mounting projectRoot read-only and cachePath for
+// output, so shared-object Windows builds (and anything else needing a
+// cross toolchain) are reproducible regardless of what's installed on the
+// operator host.
+type ContainerBuilder struct {
+	// Images maps a capability to the pinned image that handles it. A
+	// capability with no entry here is not supported by this backend.
+	Images map[containerCapability]string
+
+	runtimePath string // resolved path to docker or podman
+}
+
+func (c *ContainerBuilder) Name() string { return "container" }
+
+// Probe discovers whether docker or podman is usable on this host and that
+// every configured image is already present locally (checked with
+// `image inspect`, which does not pull), so a bad config is
+// caught at startup rather than at first build request.
+func (c *ContainerBuilder) Probe() error {
+	for _, runtimeBin := range []string{"docker", "podman"} {
+		path, err := exec.LookPath(runtimeBin)
+		if err != nil {
+			continue
+		}
+		c.runtimePath = path
+		break
+	}
+
+	if c.runtimePath == "" {
+		return fmt.Errorf("container builder: neither docker nor podman found on PATH")
+	}
+
+	for cap, image := range c.Images {
+		cmd := exec.Command(c.runtimePath, "image", "inspect", image)
+		if output, err := cmd.CombinedOutput(); err != nil {
+			return fmt.Errorf("container builder: image %q for capability %q is not available: %s: %s", image, cap, err, string(output))
+		}
+	}
+
+	return nil
+}
+
+func (c *ContainerBuilder) Supports(goos, goarch string, shared bool) bool {
+	if c.runtimePath == "" {
+		return false
+	}
+
+	_, ok := c.Images[capabilityFor(goos, shared)]
+	return ok
+}
+
+func (c *ContainerBuilder) Run(req buildRequest) ([]byte, error) {
+	image, ok := c.Images[capabilityFor(req.Goos, req.Shared)]
+	if !ok {
+		return nil, fmt.Errorf("container builder: no image configured for goos=%s shared=%v", req.Goos, req.Shared)
+	}
+
+	args := []string{
+		"run", "--rm",
+		"-v", projectRoot + ":/src:ro",
+		"-v", cachePath + ":/out",
+		"-w", "/src",
+	}
+
+	for _, e := range minimalBuildEnv(req.Env) {
+		args = append(args, "-e", e)
+	}
+
+	args = append(args, image, "go")
+	args = append(args, containerArgs(req.Args)...)
+
+	cmd := exec.Command(c.runtimePath, args...)
+
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return output, fmt.Errorf("container build via %s (%s): %s", c.runtimePath, strings.Join(args, " "), err)
+	}
+
+	return output, nil
+}
+
+// containerBuildEnvPrefixes are the only environment variables a build
+// actually needs (GOOS/GOARCH/the variant vars/CGO_ENABLED/CC). Everything
+// else in req.Env is the host process's own os.Environ(), which must not be
+// forwarded into a container running a pulled/pinned third-party image -
+// that would leak tokens, cloud-metadata creds, and anything else sitting
+// in the server's environment, and defeats the point of hermetic builds.
+var containerBuildEnvPrefixes = []string{
+	"GOOS=", "GOARCH=", "CGO_ENABLED=", "CC=",
+	"GOARM=", "GOMIPS=", "GOAMD64=", "GO386=",
+}
+
+// containerArgs rewrites the host-side absolute paths compileClient baked
+// into req.Args (the -o output path under cachePath, the cmd/client source
+// dir under projectRoot) into their container-local equivalents under the
+// /out and /src mounts set up above. Without this, every build routed
+// through this backend fails with "no such file or directory": the path
+// is only valid in the host's mount namespace, not the container's.
+func containerArgs(args []string) []string {
+	translated := make([]string, len(args))
+
+	for i, a := range args {
+		switch {
+		case strings.HasPrefix(a, cachePath):
+			translated[i] = "/out" + strings.TrimPrefix(a, cachePath)
+		case strings.HasPrefix(a, projectRoot):
+			translated[i] = "/src" + strings.TrimPrefix(a, projectRoot)
+		default:
+			translated[i] = a
+		}
+	}
+
+	return translated
+}
+
+func minimalBuildEnv(env []string) []string {
+	var minimal []string
+
+	for _, e := range env {
+		for _, prefix := range containerBuildEnvPrefixes {
+			if strings.HasPrefix(e, prefix) {
+				minimal = append(minimal, e)
+				break
+			}
+		}
+	}
+
+	return minimal
+}
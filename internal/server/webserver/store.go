@@ -0,0 +1,180 @@
+package webserver
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+const (
+	cacheDBFile  = "cache.db"
+	cacheBucket  = "files"
+	maxRemoteIPs = 8 // ring buffer size for file.RemoteIPs
+)
+
+// Store persists file records. boltStore is the only production
+// implementation; the interface exists so the legacy description.json blob
+// stays readable as a one-time migration path without coupling the rest of
+// the package to bbolt directly.
+type Store interface {
+	Put(key string, f file) error
+	Delete(key string) error
+	All() (map[string]file, error)
+	ApplyHits(deltas map[string]hitDelta) error
+	Close() error
+}
+
+// hitDelta accumulates the effect of one or more Get() calls between
+// flushes of the hit coalescer, so a hot link produces one bbolt write
+// transaction per flush interval instead of one per request.
+type hitDelta struct {
+	count     int
+	lastHitAt time.Time
+	remoteIPs []string
+}
+
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openStore(cPath string) (Store, error) {
+	db, err := bolt.Open(filepath.Join(cPath, cacheDBFile), 0600, nil)
+	if err != nil {
+		return nil, fmt.Errorf("unable to open cache store: %s", err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists([]byte(cacheBucket))
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("unable to initialise cache store: %s", err)
+	}
+
+	return &boltStore{db: db}, nil
+}
+
+func (b *boltStore) Put(key string, f file) error {
+	content, err := json.Marshal(f)
+	if err != nil {
+		return err
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(cacheBucket)).Put([]byte(key), content)
+	})
+}
+
+func (b *boltStore) Delete(key string) error {
+	return b.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(cacheBucket)).Delete([]byte(key))
+	})
+}
+
+func (b *boltStore) All() (map[string]file, error) {
+	out := make(map[string]file)
+
+	err := b.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket([]byte(cacheBucket)).ForEach(func(k, v []byte) error {
+			var f file
+			if err := json.Unmarshal(v, &f); err != nil {
+				return err
+			}
+			out[string(k)] = f
+			return nil
+		})
+	})
+
+	return out, err
+}
+
+// ApplyHits folds every pending hitDelta into the store in a single write
+// transaction. A key that was deleted since its hits were queued is simply
+// skipped rather than erroring the whole flush.
+func (b *boltStore) ApplyHits(deltas map[string]hitDelta) error {
+	if len(deltas) == 0 {
+		return nil
+	}
+
+	return b.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket([]byte(cacheBucket))
+
+		for key, d := range deltas {
+			content := bucket.Get([]byte(key))
+			if content == nil {
+				continue
+			}
+
+			var f file
+			if err := json.Unmarshal(content, &f); err != nil {
+				return err
+			}
+
+			f.Hits += d.count
+			f.LastHitAt = d.lastHitAt
+			for _, ip := range d.remoteIPs {
+				f.RemoteIPs = appendRemoteIP(f.RemoteIPs, ip)
+			}
+
+			updated, err := json.Marshal(f)
+			if err != nil {
+				return err
+			}
+
+			if err := bucket.Put([]byte(key), updated); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	})
+}
+
+func (b *boltStore) Close() error {
+	return b.db.Close()
+}
+
+// appendRemoteIP pushes ip onto a capped ring buffer, dropping the oldest
+// entry once maxRemoteIPs is reached.
+func appendRemoteIP(ips []string, ip string) []string {
+	ips = append(ips, ip)
+	if len(ips) > maxRemoteIPs {
+		ips = ips[len(ips)-maxRemoteIPs:]
+	}
+	return ips
+}
+
+// migrateLegacyCache reads the pre-bbolt description.json blob, if present,
+// so upgrading an existing install doesn't lose every cached entry. Once its
+// entries are in the store it is renamed to description.json.migrated, so an
+// empty store (every entry deleted) does not pull them back in on restart.
+func migrateLegacyCache(cPath string, store Store) (map[string]file, error) {
+	legacy := make(map[string]file)
+
+	legacyPath := filepath.Join(cPath, cacheDescriptionFile)
+	contents, err := os.ReadFile(legacyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(contents, &legacy); err != nil {
+		return nil, err
+	}
+
+	for id, f := range legacy {
+		if err := store.Put(id, f); err != nil {
+			return nil, fmt.Errorf("unable to migrate cache entry %s: %s", id, err)
+		}
+	}
+
+	if err := os.Rename(legacyPath, legacyPath+".migrated"); err != nil {
+		fmt.Println("Unable to move migrated legacy cache aside: ", err)
+	}
+
+	return legacy, nil
+}
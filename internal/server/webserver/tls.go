@@ -0,0 +1,141 @@
+package webserver
+
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/crypto/acme/autocert"
+)
+
+// TLSConfig describes how the webserver should terminate TLS for the payload
+// drop. Either a static keypair or autocert (Let's Encrypt, HTTP-01) can be
+// used; the two are mutually exclusive.
+type TLSConfig struct {
+	// CertFile/KeyFile, when both set, enable the static keypair mode.
+	CertFile string
+	KeyFile  string
+
+	// Autocert, when true, enables Let's Encrypt via HTTP-01. Hosts is the
+	// SAN allowlist autocert is permitted to issue certificates for; it
+	// should include defaultConnectBack and any --tls-host values.
+	Autocert bool
+	Hosts    []string
+
+	// HSTS, when true, adds a Strict-Transport-Security header with
+	// MaxAge (in seconds, defaults to 1 year if unset) to every response
+	// served over HTTPS.
+	HSTS   bool
+	MaxAge int
+}
+
+var (
+	tlsEnabled bool
+	tlsConfig  *tls.Config
+	hstsHeader string
+
+	// plainHTTPHandler is mounted on the plaintext HTTP listener once TLS
+	// is enabled. It's plain redirectToHTTPS for the static-keypair mode,
+	// but must be manager.HTTPHandler(redirectToHTTPS) for autocert so
+	// ACME HTTP-01 challenge requests under /.well-known/acme-challenge/
+	// get answered instead of being 301'd to a host that can't serve them
+	// yet.
+	plainHTTPHandler http.Handler
+)
+
+// EnableTLS configures the webserver to serve payloads over HTTPS. It must be
+// called before Build(), as Build() uses tlsEnabled to decide which URL
+// scheme to hand back to the operator. The fingerprint pinning done by
+// clients is unaffected by this, TLS here only protects the download hop.
+func EnableTLS(cfg TLSConfig) error {
+	if !webserverOn {
+		return fmt.Errorf("Web server is not enabled.")
+	}
+
+	switch {
+	case cfg.Autocert:
+		if len(cfg.Hosts) == 0 {
+			cfg.Hosts = []string{defaultConnectBack}
+		}
+
+		manager := &autocert.Manager{
+			Prompt:     autocert.AcceptTOS,
+			HostPolicy: autocert.HostWhitelist(cfg.Hosts...),
+			Cache:      autocert.DirCache(filepath.Join(cachePath, "acme")),
+		}
+
+		tlsConfig = manager.TLSConfig()
+		plainHTTPHandler = manager.HTTPHandler(http.HandlerFunc(redirectToHTTPS))
+
+	case cfg.CertFile != "" && cfg.KeyFile != "":
+		cert, err := tls.LoadX509KeyPair(cfg.CertFile, cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("unable to load TLS keypair: %s", err)
+		}
+
+		tlsConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+		}
+		plainHTTPHandler = http.HandlerFunc(redirectToHTTPS)
+
+	default:
+		return fmt.Errorf("EnableTLS requires either Autocert or both CertFile and KeyFile")
+	}
+
+	hstsHeader = ""
+	if cfg.HSTS {
+		maxAge := cfg.MaxAge
+		if maxAge <= 0 {
+			maxAge = 31536000 // 1 year
+		}
+		hstsHeader = fmt.Sprintf("max-age=%d; includeSubDomains", maxAge)
+	}
+
+	tlsEnabled = true
+
+	return nil
+}
+
+// scheme returns the URL scheme a freshly built payload link should use:
+// https once EnableTLS has been called, http otherwise.
+func scheme() string {
+	if tlsEnabled {
+		return "https://"
+	}
+	return "http://"
+}
+
+// redirectToHTTPS is mounted on the plaintext HTTP listener (when TLS is
+// enabled) so that bare http:// links still resolve for an operator who
+// fat-fingers the scheme, rather than silently serving the payload in the
+// clear.
+func redirectToHTTPS(w http.ResponseWriter, r *http.Request) {
+	host := r.Host
+	if h, _, err := splitHostPort(host); err == nil {
+		host = h
+	}
+
+	target := "https://" + host + r.URL.RequestURI()
+	http.Redirect(w, r, target, http.StatusMovedPermanently)
+}
+
+// hstsMiddleware stamps the configured Strict-Transport-Security header onto
+// responses served over the TLS listener, when enabled via EnableTLS.
+func hstsMiddleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if hstsHeader != "" {
+			w.Header().Set("Strict-Transport-Security", hstsHeader)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func splitHostPort(hostport string) (host, port string, err error) {
+	idx := strings.LastIndex(hostport, ":")
+	if idx == -1 {
+		return hostport, "", nil
+	}
+	return hostport[:idx], hostport[idx+1:], nil
+}